@@ -0,0 +1,100 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// oneShotMarkForDeleteTimeout bounds how long a one-shot run triggered via
+// ServeHTTPMarkForDeleteOneShot is allowed to run once the request has been
+// accepted, independent of how long the requesting HTTP client stays
+// connected.
+const oneShotMarkForDeleteTimeout = 15 * time.Minute
+
+// oneShotMarkForDeleteRequest is the body accepted by Marker's admin HTTP
+// endpoint: {"users": [...], "selector": "..."}. Either field may be
+// omitted/empty to leave that dimension of the scope unrestricted.
+type oneShotMarkForDeleteRequest struct {
+	Users    []string `json:"users"`
+	Selector string   `json:"selector"`
+}
+
+// oneShotMarkForDeleteResponse reports, per table, whether it ended up empty
+// or modified by the one-shot run.
+type oneShotMarkForDeleteResponse struct {
+	TableName string `json:"table_name"`
+	Empty     bool   `json:"empty"`
+	Modified  bool   `json:"modified"`
+}
+
+// ServeHTTPMarkForDeleteOneShot is the handler for an admin endpoint that
+// lets operators kick off a scoped retention run ad-hoc,
+// without waiting for the global schedule: POST a JSON body of
+// {"users": [...], "selector": "..."} to run retention only for those
+// tenants and/or series, e.g. to react to a single noisy/high-risk tenant.
+// tableNames and indexProcessorFor mirror MarkForDeletePreviewTables,
+// describing which tables exist and how to open an IndexProcessor for one.
+// This package does not register the handler on any HTTP router.
+func (t *Marker) ServeHTTPMarkForDeleteOneShot(w http.ResponseWriter, req *http.Request, tableNames []string, indexProcessorFor func(tableName string) (IndexProcessor, error), logger log.Logger) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body oneShotMarkForDeleteRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode request body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	scope, err := NewRetentionScope(body.Users, body.Selector)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := ""
+	if len(body.Users) == 1 {
+		userID = body.Users[0]
+	}
+
+	// A one-shot run is destructive and non-retryable per chunk; it must not
+	// be aborted just because the operator's HTTP client disconnected
+	// mid-run, so it gets its own context decoupled from the request's
+	// cancellation, bounded by its own timeout instead.
+	runCtx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), oneShotMarkForDeleteTimeout)
+	defer cancel()
+
+	responses := make([]oneShotMarkForDeleteResponse, 0, len(tableNames))
+	for _, tableName := range tableNames {
+		indexProcessor, err := indexProcessorFor(tableName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to open index for table %s: %s", tableName, err), http.StatusInternalServerError)
+			return
+		}
+
+		empty, modified, err := t.MarkForDelete(runCtx, tableName, userID, indexProcessor, scope, logger)
+		closeIndexProcessorIfCloser(indexProcessor, tableName, logger)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to mark table %s for delete: %s", tableName, err), http.StatusInternalServerError)
+			return
+		}
+
+		responses = append(responses, oneShotMarkForDeleteResponse{
+			TableName: tableName,
+			Empty:     empty,
+			Modified:  modified,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(responses); err != nil {
+		level.Error(logger).Log("msg", "failed to encode one-shot mark-for-delete response", "err", err)
+	}
+}
@@ -0,0 +1,259 @@
+package retention
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/loki/pkg/storage/chunk/client"
+	util_log "github.com/grafana/loki/pkg/util/log"
+)
+
+// objectStoreMarkersPrefix is the object store prefix under which deletion
+// marker objects are written, mirroring the `markersFolder` used for the
+// local file based backend.
+const objectStoreMarkersPrefix = "markers/"
+
+// defaultObjectStoreDeletionDelay is how long a marker has to sit in the
+// object store before the sweeper is allowed to delete the underlying chunk.
+// It exists to give all retention/compactor replicas enough time to observe
+// the marker before it is acted upon, even over eventually-consistent object
+// storage.
+const defaultObjectStoreDeletionDelay = 48 * time.Hour
+
+// defaultObjectStoreSweepInterval is how often objectStoreMarkerProcessor
+// re-lists the marker prefix while running, so markers created or aged past
+// deletionDelay after the processor started are still picked up without
+// requiring a restart.
+const defaultObjectStoreSweepInterval = 10 * time.Minute
+
+// MarkerBackend abstracts where deletion markers for chunks scheduled for
+// removal are persisted. The default implementation writes them to a local
+// file under the compactor's working directory; ObjectStoreMarkerBackend
+// stores them as objects in the chunk store instead, so that multiple
+// compactor/retention replicas can coordinate deletions without relying on a
+// shared local filesystem.
+type MarkerBackend interface {
+	NewWriter() (MarkerStorageWriter, error)
+	NewProcessor(deleteWorkerCount int, m *sweeperMetrics) (MarkerProcessor, error)
+}
+
+// chunkDeletionMark is the JSON document written for every chunk marked for
+// deletion when using the object store marker backend.
+type chunkDeletionMark struct {
+	ChunkID     string `json:"chunk_id"`
+	UserID      string `json:"user_id"`
+	MarkedAtUTC int64  `json:"marked_at_unix"`
+	Reason      string `json:"reason"`
+}
+
+// ObjectStoreMarkerBackend persists deletion markers as objects in the chunk
+// object store instead of on local disk. This lets several compactor
+// replicas share a consistent view of pending deletions without needing a
+// shared filesystem or a lock, at the cost of only deleting a chunk once its
+// marker has aged past deletionDelay.
+type ObjectStoreMarkerBackend struct {
+	client        client.ObjectClient
+	deletionDelay time.Duration
+}
+
+// NewObjectStoreMarkerBackend builds a MarkerBackend that stores deletion
+// markers as `markers/<chunkID>` objects in the chunk store. A chunk is only
+// deleted once deletionDelay has elapsed since it was marked; if deletionDelay
+// is zero, defaultObjectStoreDeletionDelay is used.
+func NewObjectStoreMarkerBackend(objectClient client.ObjectClient, deletionDelay time.Duration) *ObjectStoreMarkerBackend {
+	if deletionDelay <= 0 {
+		deletionDelay = defaultObjectStoreDeletionDelay
+	}
+	return &ObjectStoreMarkerBackend{
+		client:        objectClient,
+		deletionDelay: deletionDelay,
+	}
+}
+
+func (o *ObjectStoreMarkerBackend) NewWriter() (MarkerStorageWriter, error) {
+	return &objectStoreMarkerWriter{client: o.client}, nil
+}
+
+func (o *ObjectStoreMarkerBackend) NewProcessor(deleteWorkerCount int, m *sweeperMetrics) (MarkerProcessor, error) {
+	return &objectStoreMarkerProcessor{
+		client:            o.client,
+		deletionDelay:     o.deletionDelay,
+		deleteWorkerCount: deleteWorkerCount,
+		sweepInterval:     defaultObjectStoreSweepInterval,
+		metrics:           m,
+		quit:              make(chan struct{}),
+	}, nil
+}
+
+// localMarkerBackend is the default MarkerBackend, preserving the existing
+// behavior of writing/reading deletion markers as a local file under the
+// compactor's working directory.
+type localMarkerBackend struct {
+	workingDirectory string
+	minAgeDelete     time.Duration
+}
+
+func newLocalMarkerBackend(workingDirectory string) *localMarkerBackend {
+	return &localMarkerBackend{workingDirectory: workingDirectory}
+}
+
+func (l *localMarkerBackend) NewWriter() (MarkerStorageWriter, error) {
+	return NewMarkerStorageWriter(l.workingDirectory)
+}
+
+func (l *localMarkerBackend) NewProcessor(deleteWorkerCount int, m *sweeperMetrics) (MarkerProcessor, error) {
+	return newMarkerStorageReader(l.workingDirectory, deleteWorkerCount, l.minAgeDelete, m)
+}
+
+// objectStoreMarkerWriter uploads one deletion-mark object per marked chunk
+// instead of appending to a local file.
+type objectStoreMarkerWriter struct {
+	client client.ObjectClient
+	count  int
+}
+
+func (w *objectStoreMarkerWriter) Put(chunkID []byte) error {
+	userID, err := getUserIDFromChunkID(chunkID)
+	if err != nil {
+		return err
+	}
+
+	mark := chunkDeletionMark{
+		ChunkID:     string(chunkID),
+		UserID:      string(userID),
+		MarkedAtUTC: time.Now().Unix(),
+		Reason:      "expired",
+	}
+
+	data, err := json.Marshal(mark)
+	if err != nil {
+		return err
+	}
+
+	if err := w.client.PutObject(context.Background(), markerObjectKey(chunkID), bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to upload deletion marker for chunk %s: %w", chunkID, err)
+	}
+
+	w.count++
+	return nil
+}
+
+func (w *objectStoreMarkerWriter) Count() int {
+	return w.count
+}
+
+func (w *objectStoreMarkerWriter) Close() error {
+	return nil
+}
+
+// markerObjectKey returns the object store key a chunk's deletion marker is
+// written to. Chunk IDs may contain '/', so we keep the full chunk ID as the
+// suffix rather than trying to re-derive it from its parts.
+func markerObjectKey(chunkID []byte) string {
+	return path.Join(objectStoreMarkersPrefix, string(chunkID))
+}
+
+// objectStoreMarkerProcessor lists marker objects from the chunk store and,
+// once they have aged past deletionDelay, invokes the delete callback before
+// removing the marker object itself. It keeps re-listing on sweepInterval
+// until Stop is called, rather than running a single pass, since markers are
+// created continuously while the compactor runs and each needs to be
+// revisited once it ages past deletionDelay.
+type objectStoreMarkerProcessor struct {
+	client            client.ObjectClient
+	deletionDelay     time.Duration
+	deleteWorkerCount int
+	sweepInterval     time.Duration
+	metrics           *sweeperMetrics
+	quit              chan struct{}
+}
+
+func (p *objectStoreMarkerProcessor) Start(deleteFunc func(ctx context.Context, chunkID []byte) error) {
+	go p.run(deleteFunc)
+}
+
+func (p *objectStoreMarkerProcessor) run(deleteFunc func(ctx context.Context, chunkID []byte) error) {
+	sweepInterval := p.sweepInterval
+	if sweepInterval <= 0 {
+		sweepInterval = defaultObjectStoreSweepInterval
+	}
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	p.sweepOnce(deleteFunc)
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+			p.sweepOnce(deleteFunc)
+		}
+	}
+}
+
+// sweepOnce lists the marker prefix once and deletes every marker aged past
+// deletionDelay, stopping early if Stop is called mid-sweep.
+func (p *objectStoreMarkerProcessor) sweepOnce(deleteFunc func(ctx context.Context, chunkID []byte) error) {
+	ctx := context.Background()
+	objects, _, err := p.client.List(ctx, objectStoreMarkersPrefix, "")
+	if err != nil {
+		level.Error(util_log.Logger).Log("msg", "failed to list chunk deletion markers", "err", err)
+		return
+	}
+
+	now := time.Now()
+	for _, obj := range objects {
+		select {
+		case <-p.quit:
+			return
+		default:
+		}
+
+		mark, err := p.readMark(ctx, obj.Key)
+		if err != nil {
+			level.Error(util_log.Logger).Log("msg", "failed to read chunk deletion marker", "key", obj.Key, "err", err)
+			continue
+		}
+
+		markedAt := time.Unix(mark.MarkedAtUTC, 0)
+		if now.Sub(markedAt) < p.deletionDelay {
+			continue
+		}
+
+		if err := deleteFunc(ctx, []byte(mark.ChunkID)); err != nil {
+			level.Error(util_log.Logger).Log("msg", "failed to delete chunk referenced by marker", "chunkID", mark.ChunkID, "err", err)
+			continue
+		}
+
+		if err := p.client.DeleteObject(ctx, obj.Key); err != nil {
+			level.Error(util_log.Logger).Log("msg", "failed to remove chunk deletion marker after delete", "key", obj.Key, "err", err)
+		}
+	}
+}
+
+func (p *objectStoreMarkerProcessor) readMark(ctx context.Context, key string) (chunkDeletionMark, error) {
+	var mark chunkDeletionMark
+
+	reader, _, err := p.client.GetObject(ctx, key)
+	if err != nil {
+		return mark, err
+	}
+	defer reader.Close()
+
+	if err := json.NewDecoder(reader).Decode(&mark); err != nil {
+		return mark, err
+	}
+
+	return mark, nil
+}
+
+func (p *objectStoreMarkerProcessor) Stop() {
+	close(p.quit)
+}
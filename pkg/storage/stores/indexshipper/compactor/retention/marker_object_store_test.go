@@ -0,0 +1,159 @@
+package retention
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/storage/chunk/client"
+)
+
+func TestNewObjectStoreMarkerBackend_DefaultsDeletionDelay(t *testing.T) {
+	b := NewObjectStoreMarkerBackend(nil, 0)
+	require.Equal(t, defaultObjectStoreDeletionDelay, b.deletionDelay)
+
+	b = NewObjectStoreMarkerBackend(nil, time.Hour)
+	require.Equal(t, time.Hour, b.deletionDelay)
+}
+
+func TestMarkerObjectKey(t *testing.T) {
+	key := markerObjectKey([]byte("fake/deadbeef"))
+	require.Equal(t, "markers/fake/deadbeef", key)
+}
+
+func TestChunkDeletionMark_JSONRoundTrip(t *testing.T) {
+	mark := chunkDeletionMark{
+		ChunkID:     "fake/deadbeef",
+		UserID:      "fake",
+		MarkedAtUTC: 1234,
+		Reason:      "expired",
+	}
+
+	data, err := json.Marshal(mark)
+	require.NoError(t, err)
+
+	var decoded chunkDeletionMark
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, mark, decoded)
+}
+
+func TestGetUserIDFromChunkID(t *testing.T) {
+	userID, err := getUserIDFromChunkID([]byte("fake/deadbeef"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("fake"), userID)
+
+	_, err = getUserIDFromChunkID([]byte("no-separator"))
+	require.Error(t, err)
+}
+
+// countingListObjectClient only implements List; it always lists no markers,
+// so run's sweepOnce never reaches GetObject/DeleteObject. It exists
+// solely to count how many times the prefix gets re-listed.
+type countingListObjectClient struct {
+	client.ObjectClient
+	listCalls int64
+}
+
+func (c *countingListObjectClient) List(ctx context.Context, prefix, delimiter string) ([]client.StorageObject, []client.StorageCommonPrefix, error) {
+	atomic.AddInt64(&c.listCalls, 1)
+	return nil, nil, nil
+}
+
+func TestObjectStoreMarkerProcessor_RunSweepsRepeatedlyUntilStopped(t *testing.T) {
+	fake := &countingListObjectClient{}
+	p := &objectStoreMarkerProcessor{
+		client:        fake,
+		deletionDelay: time.Hour,
+		sweepInterval: 5 * time.Millisecond,
+		quit:          make(chan struct{}),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.run(func(ctx context.Context, chunkID []byte) error { return nil })
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&fake.listCalls) >= 3
+	}, time.Second, time.Millisecond, "run should keep re-listing the marker prefix instead of sweeping once")
+
+	close(p.quit)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("run did not return after quit was closed")
+	}
+}
+
+// readAndDeleteObjectClient backs a single marker object so sweepOnce's full
+// read/delete path can be exercised.
+type readAndDeleteObjectClient struct {
+	client.ObjectClient
+	markerData   []byte
+	deletedCalls int64
+}
+
+func (c *readAndDeleteObjectClient) List(ctx context.Context, prefix, delimiter string) ([]client.StorageObject, []client.StorageCommonPrefix, error) {
+	return []client.StorageObject{{Key: "markers/tenant-a/deadbeef"}}, nil, nil
+}
+
+func (c *readAndDeleteObjectClient) GetObject(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	return io.NopCloser(bytes.NewReader(c.markerData)), int64(len(c.markerData)), nil
+}
+
+func (c *readAndDeleteObjectClient) DeleteObject(ctx context.Context, key string) error {
+	atomic.AddInt64(&c.deletedCalls, 1)
+	return nil
+}
+
+func TestObjectStoreMarkerProcessor_SweepOnceDeletesAgedMarker(t *testing.T) {
+	data, err := json.Marshal(chunkDeletionMark{
+		ChunkID:     "tenant-a/deadbeef",
+		UserID:      "tenant-a",
+		MarkedAtUTC: time.Now().Add(-time.Hour).Unix(),
+		Reason:      "expired",
+	})
+	require.NoError(t, err)
+
+	fake := &readAndDeleteObjectClient{markerData: data}
+	p := &objectStoreMarkerProcessor{client: fake, deletionDelay: time.Minute, quit: make(chan struct{})}
+
+	var deletedChunkID string
+	p.sweepOnce(func(ctx context.Context, chunkID []byte) error {
+		deletedChunkID = string(chunkID)
+		return nil
+	})
+
+	require.Equal(t, "tenant-a/deadbeef", deletedChunkID)
+	require.EqualValues(t, 1, fake.deletedCalls)
+}
+
+func TestObjectStoreMarkerProcessor_SweepOnceSkipsUnagedMarker(t *testing.T) {
+	data, err := json.Marshal(chunkDeletionMark{
+		ChunkID:     "tenant-a/deadbeef",
+		UserID:      "tenant-a",
+		MarkedAtUTC: time.Now().Unix(),
+		Reason:      "expired",
+	})
+	require.NoError(t, err)
+
+	fake := &readAndDeleteObjectClient{markerData: data}
+	p := &objectStoreMarkerProcessor{client: fake, deletionDelay: time.Hour, quit: make(chan struct{})}
+
+	called := false
+	p.sweepOnce(func(ctx context.Context, chunkID []byte) error {
+		called = true
+		return nil
+	})
+
+	require.False(t, called, "a marker younger than deletionDelay must not be deleted yet")
+	require.EqualValues(t, 0, fake.deletedCalls)
+}
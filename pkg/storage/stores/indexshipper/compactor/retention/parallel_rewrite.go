@@ -0,0 +1,267 @@
+package retention
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// DefaultRetentionRewriteConcurrency is the number of chunks rewritten
+// concurrently when a Marker is built via NewMarker: one chunk at a time, on a
+// single worker goroutine that runs alongside the index scan.
+const DefaultRetentionRewriteConcurrency = 1
+
+// DefaultRetentionRewriteBatchSize is the number of chunks grouped into a
+// single GetChunks/PutChunks round-trip when a Marker is built via NewMarker.
+const DefaultRetentionRewriteBatchSize = 1
+
+// rewriteMetrics holds the Prometheus instrumentation for the chunk rewrite
+// worker pool, kept separate from markerMetrics since it tracks per-chunk and
+// per-batch work rather than per-table work.
+type rewriteMetrics struct {
+	chunkRewriteDurationSeconds prometheus.Histogram
+	rewriteBatchSize            prometheus.Histogram
+}
+
+func newRewriteMetrics(r prometheus.Registerer) *rewriteMetrics {
+	return &rewriteMetrics{
+		chunkRewriteDurationSeconds: promauto.With(r).NewHistogram(prometheus.HistogramOpts{
+			Namespace: "loki",
+			Subsystem: "retention",
+			Name:      "chunk_rewrite_duration_seconds",
+			Help:      "Time spent rewriting a single chunk, from fetch through re-upload.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		rewriteBatchSize: promauto.With(r).NewHistogram(prometheus.HistogramOpts{
+			Namespace: "loki",
+			Subsystem: "retention",
+			Name:      "chunk_rewrite_batch_size",
+			Help:      "Number of chunks grouped together for a single GetChunks/PutChunks round-trip.",
+			Buckets:   prometheus.LinearBuckets(1, 5, 10),
+		}),
+	}
+}
+
+// rewriteJob is a unit of work handed to the rewrite worker pool: a chunk
+// entry that survived expiration.Expired partially, along with the
+// sub-intervals of it that should be kept. markAfterRewrite mirrors the
+// synchronous marker.Put condition from markforDelete, but is only actually
+// applied once the batch containing this job has been rewritten
+// successfully, so the source chunk is never marked for deletion before its
+// replacement is confirmed durably written.
+type rewriteJob struct {
+	entry            ChunkEntry
+	intervalFilters  []IntervalFilter
+	markAfterRewrite bool
+}
+
+// rewriteResult collects the shared state mutated while processing rewrite
+// jobs that would otherwise race across worker goroutines: whether the table
+// is still non-empty, and which series are still referenced by surviving
+// chunks.
+type rewriteResult struct {
+	mu        sync.Mutex
+	empty     bool
+	seriesMap *userSeriesMap
+}
+
+func (r *rewriteResult) recordWroteChunks(seriesID, userID []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.empty = false
+	r.seriesMap.MarkSeriesNotDeleted(seriesID, userID)
+}
+
+// addSeries mirrors seriesMap.Add, guarded by the same mutex as
+// recordWroteChunks so the index scan (which calls this) and the rewrite
+// worker pool (which calls recordWroteChunks) never touch seriesMap at the
+// same time.
+func (r *rewriteResult) addSeries(seriesID, userID []byte, lbls labels.Labels) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seriesMap.Add(seriesID, userID, lbls)
+}
+
+// markSeriesNotDeleted mirrors seriesMap.MarkSeriesNotDeleted for callers on
+// the index-scan goroutine, guarded by the same mutex as recordWroteChunks.
+func (r *rewriteResult) markSeriesNotDeleted(seriesID, userID []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.empty = false
+	r.seriesMap.MarkSeriesNotDeleted(seriesID, userID)
+}
+
+// rewritePool fans jobs submitted via submit out across concurrency worker
+// goroutines, each batching up to batchSize jobs into a single
+// chunkRewriter.rewriteChunkBatch call so GetChunks/PutChunks round-trips to
+// the object store amortize across chunks instead of happening one at a
+// time. Errors from any worker are captured and surfaced from wait.
+//
+// marker.Put for a job is only called once the batch containing it has been
+// rewritten successfully, guarded by markerMu since marker writers aren't
+// expected to tolerate concurrent calls: this preserves the original
+// guarantee that a chunk is never marked for deletion before the chunk
+// holding its surviving data has been durably written.
+type rewritePool struct {
+	rewriter      *chunkRewriter
+	ctx           context.Context
+	tableInterval model.Interval
+	concurrency   int
+	batchSize     int
+	metrics       *rewriteMetrics
+	result        *rewriteResult
+	marker        MarkerStorageWriter
+
+	jobs chan rewriteJob
+	wg   sync.WaitGroup
+
+	errMu sync.Mutex
+	err   error
+
+	markerMu sync.Mutex
+}
+
+func newRewritePool(ctx context.Context, rewriter *chunkRewriter, tableInterval model.Interval, concurrency, batchSize int, metrics *rewriteMetrics, result *rewriteResult, marker MarkerStorageWriter) *rewritePool {
+	if concurrency < 1 {
+		concurrency = DefaultRetentionRewriteConcurrency
+	}
+	if batchSize < 1 {
+		batchSize = DefaultRetentionRewriteBatchSize
+	}
+
+	p := &rewritePool{
+		rewriter:      rewriter,
+		ctx:           ctx,
+		tableInterval: tableInterval,
+		concurrency:   concurrency,
+		batchSize:     batchSize,
+		metrics:       metrics,
+		result:        result,
+		marker:        marker,
+		jobs:          make(chan rewriteJob, concurrency*batchSize),
+	}
+
+	for i := 0; i < concurrency; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	return p
+}
+
+// submit hands a chunk needing a partial rewrite off to the pool.
+// markAfterRewrite mirrors the synchronous marker.Put condition markforDelete
+// would otherwise apply immediately; the pool instead applies it once this
+// job's batch has actually succeeded. entry is defensively copied before it
+// crosses the channel: ChunkIterator implementations are free to reuse the
+// backing buffer behind ChunkID/SeriesID/UserID for the duration of a single
+// ForEachChunk callback (the usual zero-copy pattern for a boltdb-style
+// cursor), and a worker goroutine can still be reading them well after that
+// callback, and even the whole scan, has returned.
+func (p *rewritePool) submit(entry ChunkEntry, intervalFilters []IntervalFilter, markAfterRewrite bool) {
+	entry = cloneChunkEntry(entry)
+	select {
+	case p.jobs <- rewriteJob{entry: entry, intervalFilters: intervalFilters, markAfterRewrite: markAfterRewrite}:
+	case <-p.ctx.Done():
+	}
+}
+
+// cloneChunkEntry copies the byte slices read off the index scan goroutine so
+// they remain valid once owned by the rewrite worker pool. Labels is left
+// alone: label values are immutable Go strings, so copying the slice header
+// is enough even if the backing array came from a reused buffer.
+func cloneChunkEntry(entry ChunkEntry) ChunkEntry {
+	entry.ChunkID = append([]byte(nil), entry.ChunkID...)
+	entry.SeriesID = append([]byte(nil), entry.SeriesID...)
+	entry.UserID = append([]byte(nil), entry.UserID...)
+	return entry
+}
+
+func (p *rewritePool) work() {
+	defer p.wg.Done()
+
+	batch := make([]rewriteJob, 0, p.batchSize)
+	for job := range p.jobs {
+		batch = append(batch, job)
+
+		// opportunistically drain more queued jobs, up to batchSize, so a
+		// single GetChunks/PutChunks call covers the whole batch.
+	drain:
+		for len(batch) < p.batchSize {
+			select {
+			case next, ok := <-p.jobs:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		p.processBatch(batch)
+		batch = batch[:0]
+	}
+}
+
+func (p *rewritePool) processBatch(batch []rewriteJob) {
+	if len(batch) == 0 {
+		return
+	}
+
+	start := time.Now()
+	p.metrics.rewriteBatchSize.Observe(float64(len(batch)))
+
+	results, err := p.rewriter.rewriteChunkBatch(p.ctx, p.tableInterval, batch)
+	p.metrics.chunkRewriteDurationSeconds.Observe(time.Since(start).Seconds() / float64(len(batch)))
+	if err != nil {
+		// the batch failed: none of its jobs' source chunks are marked for
+		// deletion, since we can't confirm their replacement data was written.
+		p.setErr(err)
+		return
+	}
+
+	for _, job := range batch {
+		if results[string(job.entry.ChunkID)] {
+			p.result.recordWroteChunks(job.entry.SeriesID, job.entry.UserID)
+		}
+
+		if job.markAfterRewrite {
+			if err := p.putMarker(job.entry.ChunkID); err != nil {
+				p.setErr(err)
+				return
+			}
+		}
+	}
+}
+
+// putMarker calls marker.Put for a chunk whose replacement data the pool has
+// just confirmed was rewritten successfully, serialized against other
+// workers since MarkerStorageWriter implementations aren't expected to
+// tolerate concurrent calls.
+func (p *rewritePool) putMarker(chunkID []byte) error {
+	p.markerMu.Lock()
+	defer p.markerMu.Unlock()
+	return p.marker.Put(chunkID)
+}
+
+func (p *rewritePool) setErr(err error) {
+	p.errMu.Lock()
+	defer p.errMu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// wait closes the job queue, waits for every worker to drain it, and returns
+// the first error observed by any worker, if any.
+func (p *rewritePool) wait() error {
+	close(p.jobs)
+	p.wg.Wait()
+	return p.err
+}
@@ -0,0 +1,164 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// DeletedChunkPreview describes a chunk that a real retention run would have
+// deleted outright.
+type DeletedChunkPreview struct {
+	ChunkID string `json:"chunk_id"`
+	UserID  string `json:"user_id"`
+}
+
+// RewrittenChunkPreview describes a chunk that a real retention run would
+// have partially deleted, replacing it with one chunk per surviving
+// sub-interval.
+type RewrittenChunkPreview struct {
+	ChunkID       string   `json:"chunk_id"`
+	UserID        string   `json:"user_id"`
+	KeptIntervals []string `json:"kept_intervals"`
+	BytesAffected int64    `json:"bytes_affected"`
+}
+
+// CleanedSeriesPreview describes a series that a real retention run would
+// have dropped from the index because none of its chunks survive.
+type CleanedSeriesPreview struct {
+	UserID string `json:"user_id"`
+	Labels string `json:"labels"`
+}
+
+// TablePreviewReport is the structured result of running MarkForDeletePreview
+// against a single table: what would have happened had DryRun been false.
+type TablePreviewReport struct {
+	TableName          string                  `json:"table_name"`
+	DeletedChunks      []DeletedChunkPreview   `json:"deleted_chunks"`
+	RewrittenChunks    []RewrittenChunkPreview `json:"rewritten_chunks"`
+	CleanedSeries      []CleanedSeriesPreview  `json:"cleaned_series"`
+	TotalBytesAffected int64                   `json:"total_bytes_affected"`
+}
+
+// WriteReport writes report to w as indented JSON, for operators inspecting
+// the result of a preview run from the command line or an admin endpoint.
+func WriteReport(w io.Writer, report *TablePreviewReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// MarkForDeletePreview runs the same expiration and chunk-rewrite planning as
+// MarkForDelete but performs no destructive action: it never writes to the
+// marker backend, never calls chunkIndexer.IndexChunk, and never uploads
+// rewritten chunks via PutChunks. A non-nil scope restricts the preview to
+// the tenants/label matchers it describes, exactly as a real MarkForDelete
+// run would. It returns a report describing what a real run against the same
+// table and index would have done, so operators can validate retention rules
+// against a production index before enabling them.
+func (t *Marker) MarkForDeletePreview(ctx context.Context, tableName string, scope *RetentionScope, indexProcessor IndexProcessor, logger log.Logger) (*TablePreviewReport, error) {
+	level.Debug(logger).Log("msg", "starting dry-run of table", "table", tableName)
+
+	report := &TablePreviewReport{TableName: tableName}
+	chunkRewriter := newChunkRewriter(t.chunkClient, tableName, indexProcessor)
+	chunkRewriter.preview = report
+
+	previewIndex := &previewIndexProcessor{IndexProcessor: indexProcessor, report: report}
+
+	_, _, err := markforDelete(ctx, tableName, &previewMarkerWriter{report: report}, previewIndex, t.expiration, chunkRewriter,
+		t.rewriteConcurrency, t.rewriteBatchSize, t.rewriteMetrics, scope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to preview table %s: %w", tableName, err)
+	}
+
+	return report, nil
+}
+
+// MarkForDeletePreviewTables runs MarkForDeletePreview across a set of
+// tables, optionally scoped to allowedUsers. It is the entry point an admin
+// HTTP endpoint on the compactor would call to let operators validate
+// retention rules for a specific set of tenants before enabling deletion,
+// without waiting for the scheduled retention run. indexProcessorFor builds
+// the IndexProcessor for a given table, mirroring how the compactor already
+// opens one index file per table for a real run.
+func (t *Marker) MarkForDeletePreviewTables(ctx context.Context, tableNames []string, allowedUsers []string, indexProcessorFor func(tableName string) (IndexProcessor, error), logger log.Logger) ([]*TablePreviewReport, error) {
+	scope, err := NewRetentionScope(allowedUsers, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to build retention scope: %w", err)
+	}
+
+	reports := make([]*TablePreviewReport, 0, len(tableNames))
+	for _, tableName := range tableNames {
+		if ctx.Err() != nil {
+			return reports, ctx.Err()
+		}
+
+		indexProcessor, err := indexProcessorFor(tableName)
+		if err != nil {
+			return reports, fmt.Errorf("failed to open index for table %s: %w", tableName, err)
+		}
+
+		report, err := t.MarkForDeletePreview(ctx, tableName, scope, indexProcessor, logger)
+		closeIndexProcessorIfCloser(indexProcessor, tableName, logger)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// previewMarkerWriter satisfies MarkerStorageWriter without persisting
+// anything, so MarkForDeletePreview never touches the marker backend; it
+// records each chunk that would have been marked for deletion instead.
+type previewMarkerWriter struct {
+	report *TablePreviewReport
+	count  int
+}
+
+func (p *previewMarkerWriter) Put(chunkID []byte) error {
+	userID, err := getUserIDFromChunkID(chunkID)
+	if err != nil {
+		return err
+	}
+	p.report.DeletedChunks = append(p.report.DeletedChunks, DeletedChunkPreview{
+		ChunkID: string(chunkID),
+		UserID:  string(userID),
+	})
+	p.count++
+	return nil
+}
+
+func (p *previewMarkerWriter) Count() int   { return p.count }
+func (p *previewMarkerWriter) Close() error { return nil }
+
+// previewIndexProcessor wraps an IndexProcessor so that MarkForDeletePreview
+// can drive the real ForEachChunk iteration while redirecting the series
+// cleanup step, which would otherwise mutate the index, into the report.
+type previewIndexProcessor struct {
+	IndexProcessor
+	report *TablePreviewReport
+}
+
+// ForEachChunk iterates the real index but never asks it to drop an entry:
+// the callback's deleteChunk result is discarded.
+func (p *previewIndexProcessor) ForEachChunk(callback ChunkEntryCallback) error {
+	return p.IndexProcessor.ForEachChunk(func(c ChunkEntry) (bool, error) {
+		_, err := callback(c)
+		return false, err
+	})
+}
+
+func (p *previewIndexProcessor) CleanupSeries(userID []byte, lbls labels.Labels) error {
+	p.report.CleanedSeries = append(p.report.CleanedSeries, CleanedSeriesPreview{
+		UserID: string(userID),
+		Labels: lbls.String(),
+	})
+	return nil
+}
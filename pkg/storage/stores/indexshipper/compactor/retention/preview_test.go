@@ -0,0 +1,53 @@
+package retention
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/storage/chunk"
+)
+
+// failingIndexProcessor is embedded by previewIndexProcessor in these tests;
+// every method panics if called, so a passing test proves the preview path
+// never delegates to the real index.
+type failingIndexProcessor struct{}
+
+func (failingIndexProcessor) ForEachChunk(ChunkEntryCallback) error {
+	panic("ForEachChunk should not be called by the preview path under test")
+}
+
+func (failingIndexProcessor) IndexChunk(chunk.Chunk) (bool, error) {
+	panic("IndexChunk must never be called while previewing")
+}
+
+func (failingIndexProcessor) CleanupSeries([]byte, labels.Labels) error {
+	panic("CleanupSeries must never be called while previewing")
+}
+
+func TestPreviewMarkerWriter_RecordsWithoutPersisting(t *testing.T) {
+	report := &TablePreviewReport{TableName: "table1"}
+	w := &previewMarkerWriter{report: report}
+
+	require.NoError(t, w.Put([]byte("tenant-a/deadbeef")))
+	require.Equal(t, 1, w.Count())
+	require.NoError(t, w.Close())
+
+	require.Equal(t, []DeletedChunkPreview{{ChunkID: "tenant-a/deadbeef", UserID: "tenant-a"}}, report.DeletedChunks)
+}
+
+func TestPreviewMarkerWriter_InvalidChunkID(t *testing.T) {
+	w := &previewMarkerWriter{report: &TablePreviewReport{}}
+	require.Error(t, w.Put([]byte("no-separator")))
+}
+
+func TestPreviewIndexProcessor_CleanupSeriesRecordsInsteadOfDeleting(t *testing.T) {
+	report := &TablePreviewReport{TableName: "table1"}
+	p := &previewIndexProcessor{IndexProcessor: failingIndexProcessor{}, report: report}
+
+	lbls := labels.FromStrings("app", "noisy")
+	require.NoError(t, p.CleanupSeries([]byte("tenant-a"), lbls))
+
+	require.Equal(t, []CleanedSeriesPreview{{UserID: "tenant-a", Labels: lbls.String()}}, report.CleanedSeries)
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/go-kit/log"
@@ -72,9 +73,28 @@ type IndexProcessor interface {
 var errNoChunksFound = errors.New("no chunks found in table, please check if there are really no chunks and manually drop the table or " +
 	"see if there is a bug causing us to drop whole index table")
 
+// closeIndexProcessorIfCloser releases an IndexProcessor built for a single
+// table once that table has been processed. IndexProcessor itself has no
+// Close method since most callers (a single MarkForDelete/MarkForDeletePreview
+// run) only ever open one and let the caller's own index handle manage its
+// lifetime, but callers that loop over many tables (MarkForDeletePreviewTables,
+// ServeHTTPMarkForDeleteOneShot) would otherwise accumulate one open file
+// handle/transaction per table for implementations that hold one.
+func closeIndexProcessorIfCloser(indexProcessor IndexProcessor, tableName string, logger log.Logger) {
+	closer, ok := indexProcessor.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		level.Error(logger).Log("msg", "failed to close index processor", "table", tableName, "err", err)
+	}
+}
+
 type TableMarker interface {
 	// MarkForDelete marks chunks to delete for a given table and returns if it's empty or modified.
-	MarkForDelete(ctx context.Context, tableName, userID string, indexProcessor IndexProcessor, logger log.Logger) (bool, bool, error)
+	// A non-nil scope narrows the run to a subset of chunks (by tenant and/or label matchers);
+	// chunks outside the scope are left untouched.
+	MarkForDelete(ctx context.Context, tableName, userID string, indexProcessor IndexProcessor, scope *RetentionScope, logger log.Logger) (bool, bool, error)
 }
 
 type Marker struct {
@@ -82,20 +102,55 @@ type Marker struct {
 	expiration       ExpirationChecker
 	markerMetrics    *markerMetrics
 	chunkClient      client.Client
+	markerBackend    MarkerBackend
+
+	rewriteConcurrency int
+	rewriteBatchSize   int
+	rewriteMetrics     *rewriteMetrics
 }
 
 func NewMarker(workingDirectory string, expiration ExpirationChecker, chunkClient client.Client, r prometheus.Registerer) (*Marker, error) {
+	return NewMarkerWithBackend(workingDirectory, expiration, chunkClient, nil, r)
+}
+
+// NewMarkerWithBackend is like NewMarker but allows overriding how deletion
+// markers are persisted. A nil backend keeps the default local-file based
+// behavior, writing markers under workingDirectory; pass an
+// ObjectStoreMarkerBackend to coordinate deletions across multiple
+// compactor/retention replicas over the chunk object store instead.
+func NewMarkerWithBackend(workingDirectory string, expiration ExpirationChecker, chunkClient client.Client, backend MarkerBackend, r prometheus.Registerer) (*Marker, error) {
+	return NewMarkerWithRewriteConcurrency(workingDirectory, expiration, chunkClient, backend,
+		DefaultRetentionRewriteConcurrency, DefaultRetentionRewriteBatchSize, r)
+}
+
+// NewMarkerWithRewriteConcurrency is like NewMarkerWithBackend but additionally
+// exposes RetentionRewriteConcurrency and RetentionRewriteBatchSize: how many
+// chunks are rewritten in parallel, and how many chunks are grouped into a
+// single GetChunks/PutChunks round-trip against the chunk store. Tenants with
+// large deletion requests benefit from raising both so object store
+// round-trips amortize across chunks instead of dominating compaction time.
+func NewMarkerWithRewriteConcurrency(workingDirectory string, expiration ExpirationChecker, chunkClient client.Client, backend MarkerBackend,
+	retentionRewriteConcurrency, retentionRewriteBatchSize int, r prometheus.Registerer) (*Marker, error) {
 	metrics := newMarkerMetrics(r)
+	if backend == nil {
+		backend = newLocalMarkerBackend(workingDirectory)
+	}
 	return &Marker{
-		workingDirectory: workingDirectory,
-		expiration:       expiration,
-		markerMetrics:    metrics,
-		chunkClient:      chunkClient,
+		workingDirectory:   workingDirectory,
+		expiration:         expiration,
+		markerMetrics:      metrics,
+		chunkClient:        chunkClient,
+		markerBackend:      backend,
+		rewriteConcurrency: retentionRewriteConcurrency,
+		rewriteBatchSize:   retentionRewriteBatchSize,
+		rewriteMetrics:     newRewriteMetrics(r),
 	}, nil
 }
 
-// MarkForDelete marks all chunks expired for a given table.
-func (t *Marker) MarkForDelete(ctx context.Context, tableName, userID string, indexProcessor IndexProcessor, logger log.Logger) (bool, bool, error) {
+// MarkForDelete marks all chunks expired for a given table. A non-nil scope
+// restricts the run to the tenants/label matchers it describes; chunks
+// outside the scope are left completely untouched.
+func (t *Marker) MarkForDelete(ctx context.Context, tableName, userID string, indexProcessor IndexProcessor, scope *RetentionScope, logger log.Logger) (bool, bool, error) {
 	start := time.Now()
 	status := statusSuccess
 	defer func() {
@@ -104,7 +159,7 @@ func (t *Marker) MarkForDelete(ctx context.Context, tableName, userID string, in
 	}()
 	level.Debug(logger).Log("msg", "starting to process table")
 
-	empty, modified, err := t.markTable(ctx, tableName, userID, indexProcessor)
+	empty, modified, err := t.markTable(ctx, tableName, userID, indexProcessor, scope)
 	if err != nil {
 		status = statusFailure
 		return false, false, err
@@ -112,8 +167,8 @@ func (t *Marker) MarkForDelete(ctx context.Context, tableName, userID string, in
 	return empty, modified, nil
 }
 
-func (t *Marker) markTable(ctx context.Context, tableName, userID string, indexProcessor IndexProcessor) (bool, bool, error) {
-	markerWriter, err := NewMarkerStorageWriter(t.workingDirectory)
+func (t *Marker) markTable(ctx context.Context, tableName, userID string, indexProcessor IndexProcessor, scope *RetentionScope) (bool, bool, error) {
+	markerWriter, err := t.markerBackend.NewWriter()
 	if err != nil {
 		return false, false, fmt.Errorf("failed to create marker writer: %w", err)
 	}
@@ -124,7 +179,8 @@ func (t *Marker) markTable(ctx context.Context, tableName, userID string, indexP
 
 	chunkRewriter := newChunkRewriter(t.chunkClient, tableName, indexProcessor)
 
-	empty, modified, err := markforDelete(ctx, tableName, markerWriter, indexProcessor, t.expiration, chunkRewriter)
+	empty, modified, err := markforDelete(ctx, tableName, markerWriter, indexProcessor, t.expiration, chunkRewriter,
+		t.rewriteConcurrency, t.rewriteBatchSize, t.rewriteMetrics, scope)
 	if err != nil {
 		return false, false, err
 	}
@@ -147,7 +203,8 @@ func (t *Marker) markTable(ctx context.Context, tableName, userID string, indexP
 }
 
 func markforDelete(ctx context.Context, tableName string, marker MarkerStorageWriter, indexFile IndexProcessor,
-	expiration ExpirationChecker, chunkRewriter *chunkRewriter) (bool, bool, error) {
+	expiration ExpirationChecker, chunkRewriter *chunkRewriter, rewriteConcurrency, rewriteBatchSize int, metrics *rewriteMetrics,
+	scope *RetentionScope) (bool, bool, error) {
 	seriesMap := newUserSeriesMap()
 	// tableInterval holds the interval for which the table is expected to have the chunks indexed
 	tableInterval := ExtractIntervalFromTableName(tableName)
@@ -155,36 +212,70 @@ func markforDelete(ctx context.Context, tableName string, marker MarkerStorageWr
 	modified := false
 	now := model.Now()
 	chunksFound := false
+	scopeSkipped := false
+
+	result := &rewriteResult{empty: true, seriesMap: seriesMap}
+	pool := newRewritePool(ctx, chunkRewriter, tableInterval, rewriteConcurrency, rewriteBatchSize, metrics, result, marker)
 
 	err := indexFile.ForEachChunk(func(c ChunkEntry) (bool, error) {
 		chunksFound = true
-		seriesMap.Add(c.SeriesID, c.UserID, c.Labels)
+
+		// Chunks outside the scope are skipped entirely: not evaluated against
+		// ExpirationChecker or DropFromIndex, and excluded from seriesMap, so a
+		// scoped run never decides to clean up a series it never looked at.
+		if !scope.Allows(c) {
+			scopeSkipped = true
+			return false, nil
+		}
+
+		// routed through result rather than called directly on seriesMap: the
+		// rewrite worker pool mutates the same seriesMap concurrently from its
+		// own goroutines once chunks are submitted below.
+		result.addSeries(c.SeriesID, c.UserID, c.Labels)
 
 		// see if the chunk is deleted completely or partially
 		if expired, nonDeletedIntervalFilters := expiration.Expired(c, now); expired {
-			if len(nonDeletedIntervalFilters) > 0 {
+			modified = true
+
+			// Mark the chunk for deletion only if it is completely deleted, or this is the last table that the chunk is index in.
+			// For a partially deleted chunk, if we delete the source chunk before all the tables which index it are processed then
+			// the retention would fail because it would fail to find it in the storage.
+			markNow := len(nonDeletedIntervalFilters) == 0 || c.Through <= tableInterval.End
+
+			if len(nonDeletedIntervalFilters) == 0 {
+				if markNow {
+					if err := marker.Put(c.ChunkID); err != nil {
+						return false, err
+					}
+				}
+				return true, nil
+			}
+
+			if chunkRewriter.preview != nil {
+				// dry-run: rewrite synchronously so the report reflects exactly
+				// what MarkForDeletePreview found, without touching the pool.
 				wroteChunks, err := chunkRewriter.rewriteChunk(ctx, c, tableInterval, nonDeletedIntervalFilters)
 				if err != nil {
 					return false, fmt.Errorf("failed to rewrite chunk %s for intervals %+v with error %s", c.ChunkID, nonDeletedIntervalFilters, err)
 				}
-
 				if wroteChunks {
-					// we have re-written chunk to the storage so the table won't be empty and the series are still being referred.
 					empty = false
-					seriesMap.MarkSeriesNotDeleted(c.SeriesID, c.UserID)
+					result.markSeriesNotDeleted(c.SeriesID, c.UserID)
 				}
-			}
-
-			modified = true
-
-			// Mark the chunk for deletion only if it is completely deleted, or this is the last table that the chunk is index in.
-			// For a partially deleted chunk, if we delete the source chunk before all the tables which index it are processed then
-			// the retention would fail because it would fail to find it in the storage.
-			if len(nonDeletedIntervalFilters) == 0 || c.Through <= tableInterval.End {
-				if err := marker.Put(c.ChunkID); err != nil {
-					return false, err
+				if markNow {
+					if err := marker.Put(c.ChunkID); err != nil {
+						return false, err
+					}
 				}
+				return true, nil
 			}
+
+			// handed off to the rewrite worker pool: GetChunks/Rebound/Encode/IndexChunk/PutChunks
+			// for this chunk happen concurrently with the rest of the index scan, batched with
+			// other pending rewrites. marker.Put for this chunk is deferred until the pool confirms
+			// the batch containing it succeeded, so the source is never marked for deletion before
+			// its replacement data has actually been written.
+			pool.submit(c, nonDeletedIntervalFilters, markNow)
 			return true, nil
 		}
 
@@ -200,13 +291,25 @@ func markforDelete(ctx context.Context, tableName string, marker MarkerStorageWr
 		}
 
 		empty = false
-		seriesMap.MarkSeriesNotDeleted(c.SeriesID, c.UserID)
+		result.markSeriesNotDeleted(c.SeriesID, c.UserID)
 		return false, nil
 	})
 	if err != nil {
 		return false, false, err
 	}
 
+	if rewriteErr := pool.wait(); rewriteErr != nil {
+		return false, false, rewriteErr
+	}
+	if !result.empty {
+		empty = false
+	}
+	if scopeSkipped {
+		// a scoped run never examined every chunk in the table, so it can never
+		// conclude the whole table is safe to drop.
+		empty = false
+	}
+
 	if !chunksFound {
 		return false, false, errNoChunksFound
 	}
@@ -235,18 +338,50 @@ type Sweeper struct {
 	markerProcessor MarkerProcessor
 	chunkClient     ChunkClient
 	sweeperMetrics  *sweeperMetrics
+
+	workingDirectory    string
+	indexLookup         IndexLookup
+	verifyBeforeDelete  bool
+	verificationMetrics *verificationMetrics
 }
 
 func NewSweeper(workingDir string, deleteClient ChunkClient, deleteWorkerCount int, minAgeDelete time.Duration, r prometheus.Registerer) (*Sweeper, error) {
+	backend := newLocalMarkerBackend(workingDir)
+	backend.minAgeDelete = minAgeDelete
+	return NewSweeperWithBackend(workingDir, backend, deleteClient, deleteWorkerCount, r)
+}
+
+// NewSweeperWithBackend is like NewSweeper but reads deletion markers from the
+// given MarkerBackend instead of always reading them off local disk, so the
+// sweeper can be paired with an ObjectStoreMarkerBackend when multiple
+// compactor/retention replicas are coordinating deletions. workingDir is
+// still needed to hold the quarantine folder used when verification is
+// enabled, even if markers themselves come from the object store.
+func NewSweeperWithBackend(workingDir string, backend MarkerBackend, deleteClient ChunkClient, deleteWorkerCount int, r prometheus.Registerer) (*Sweeper, error) {
+	return NewSweeperWithVerification(workingDir, backend, deleteClient, deleteWorkerCount, nil, false, r)
+}
+
+// NewSweeperWithVerification is like NewSweeperWithBackend but additionally
+// enables a verification pass before deletion: each chunk is checked against
+// indexLookup immediately before being deleted, and quarantined instead of
+// deleted if it still resolves in the index or cannot be verified at all.
+// Pass verifyBeforeDelete=false (or a nil indexLookup) to keep deleting
+// chunks unconditionally, matching --retention.verify-before-delete=false.
+func NewSweeperWithVerification(workingDir string, backend MarkerBackend, deleteClient ChunkClient, deleteWorkerCount int,
+	indexLookup IndexLookup, verifyBeforeDelete bool, r prometheus.Registerer) (*Sweeper, error) {
 	m := newSweeperMetrics(r)
-	p, err := newMarkerStorageReader(workingDir, deleteWorkerCount, minAgeDelete, m)
+	p, err := backend.NewProcessor(deleteWorkerCount, m)
 	if err != nil {
 		return nil, err
 	}
 	return &Sweeper{
-		markerProcessor: p,
-		chunkClient:     deleteClient,
-		sweeperMetrics:  m,
+		markerProcessor:     p,
+		chunkClient:         deleteClient,
+		sweeperMetrics:      m,
+		workingDirectory:    workingDir,
+		indexLookup:         indexLookup,
+		verifyBeforeDelete:  verifyBeforeDelete,
+		verificationMetrics: newVerificationMetrics(r),
 	}, nil
 }
 
@@ -263,6 +398,15 @@ func (s *Sweeper) Start() {
 			return err
 		}
 
+		safeToDelete, err := s.verifyAndMaybeQuarantine(ctx, unsafeGetString(userID), chunkIDString)
+		if err != nil {
+			status = statusFailure
+			return err
+		}
+		if !safeToDelete {
+			return nil
+		}
+
 		err = s.chunkClient.DeleteChunk(ctx, unsafeGetString(userID), chunkIDString)
 		if s.chunkClient.IsChunkNotFoundErr(err) {
 			status = statusNotFound
@@ -294,6 +438,11 @@ type chunkRewriter struct {
 	chunkClient  client.Client
 	tableName    string
 	chunkIndexer chunkIndexer
+	// preview, when non-nil, puts the rewriter in dry-run mode: it still
+	// reads and rebounds the source chunk to work out what would change,
+	// but skips IndexChunk and PutChunks and records the outcome on the
+	// report instead.
+	preview *TablePreviewReport
 }
 
 func newChunkRewriter(chunkClient client.Client, tableName string, chunkIndexer chunkIndexer) *chunkRewriter {
@@ -359,6 +508,23 @@ func (c *chunkRewriter) rewriteChunk(ctx context.Context, ce ChunkEntry, tableIn
 			return false, err
 		}
 
+		if c.preview != nil {
+			// one report entry per source chunk, collecting every kept interval;
+			// IDs are copied since the iterator may reuse their buffers.
+			if !wroteChunks {
+				c.preview.RewrittenChunks = append(c.preview.RewrittenChunks, RewrittenChunkPreview{
+					ChunkID: string(ce.ChunkID),
+					UserID:  string(ce.UserID),
+				})
+			}
+			rewritten := &c.preview.RewrittenChunks[len(c.preview.RewrittenChunks)-1]
+			rewritten.KeptIntervals = append(rewritten.KeptIntervals, fmt.Sprintf("[%s,%s]", start, end))
+			rewritten.BytesAffected += int64(len(newChunk.Encoded))
+			c.preview.TotalBytesAffected += int64(len(newChunk.Encoded))
+			wroteChunks = true
+			continue
+		}
+
 		uploadChunk, err := c.chunkIndexer.IndexChunk(newChunk)
 		if err != nil {
 			return false, err
@@ -376,3 +542,99 @@ func (c *chunkRewriter) rewriteChunk(ctx context.Context, ce ChunkEntry, tableIn
 
 	return wroteChunks, nil
 }
+
+// rewriteChunkBatch is the batched counterpart to rewriteChunk: it fetches
+// every chunk referenced by jobs with a single GetChunks call and uploads
+// every rewritten chunk with a single PutChunks call, so that object store
+// round-trips amortize across the whole batch instead of happening once per
+// chunk. It returns whether each chunk (keyed by its ChunkID) was rewritten
+// to at least one surviving sub-interval.
+func (c *chunkRewriter) rewriteChunkBatch(ctx context.Context, tableInterval model.Interval, jobs []rewriteJob) (map[string]bool, error) {
+	wroteChunks := make(map[string]bool, len(jobs))
+
+	chks := make([]chunk.Chunk, 0, len(jobs))
+	for _, job := range jobs {
+		userID := unsafeGetString(job.entry.UserID)
+		chunkID := unsafeGetString(job.entry.ChunkID)
+
+		chk, err := chunk.ParseExternalKey(userID, chunkID)
+		if err != nil {
+			return nil, err
+		}
+		chks = append(chks, chk)
+	}
+
+	fetched, err := c.chunkClient.GetChunks(ctx, chks)
+	if err != nil {
+		return nil, err
+	}
+	if len(fetched) != len(jobs) {
+		return nil, fmt.Errorf("expected %d entries but found %d in storage", len(jobs), len(fetched))
+	}
+
+	newChunks := make([]chunk.Chunk, 0, len(jobs))
+	uploadedFor := make([]string, 0, len(jobs))
+
+	for i, job := range jobs {
+		ce := job.entry
+		userID := unsafeGetString(ce.UserID)
+		chunkID := string(ce.ChunkID)
+		src := fetched[i]
+
+		for _, ivf := range job.intervalFilters {
+			start := ivf.Interval.Start
+			end := ivf.Interval.End
+
+			newChunkData, err := src.Data.Rebound(start, end, ivf.Filter)
+			if err != nil {
+				if errors.Is(err, chunk.ErrSliceNoDataInRange) {
+					level.Info(util_log.Logger).Log("msg", "Rebound leaves an empty chunk", "chunk ref", string(ce.ChunkRef.ChunkID))
+					continue
+				}
+				return nil, err
+			}
+
+			if start > tableInterval.End || end < tableInterval.Start {
+				continue
+			}
+
+			facade, ok := newChunkData.(*chunkenc.Facade)
+			if !ok {
+				return nil, errors.New("invalid chunk type")
+			}
+
+			newChunk := chunk.NewChunk(
+				userID, src.FingerprintModel(), src.Metric,
+				facade,
+				start,
+				end,
+			)
+
+			if err := newChunk.Encode(); err != nil {
+				return nil, err
+			}
+
+			uploadChunk, err := c.chunkIndexer.IndexChunk(newChunk)
+			if err != nil {
+				return nil, err
+			}
+
+			if uploadChunk {
+				newChunks = append(newChunks, newChunk)
+				uploadedFor = append(uploadedFor, chunkID)
+			}
+		}
+	}
+
+	if len(newChunks) > 0 {
+		if err := c.chunkClient.PutChunks(ctx, newChunks); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, chunkID := range uploadedFor {
+		wroteChunks[chunkID] = true
+	}
+
+	return wroteChunks, nil
+}
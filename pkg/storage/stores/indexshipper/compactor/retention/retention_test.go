@@ -0,0 +1,33 @@
+package retention
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+type closingIndexProcessor struct {
+	IndexProcessor
+	closed bool
+}
+
+func (c *closingIndexProcessor) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestCloseIndexProcessorIfCloser_ClosesWhenSupported(t *testing.T) {
+	p := &closingIndexProcessor{}
+	closeIndexProcessorIfCloser(p, "table1", log.NewNopLogger())
+	require.True(t, p.closed)
+}
+
+func TestCloseIndexProcessorIfCloser_NoopWhenNotACloser(t *testing.T) {
+	// failingIndexProcessor (preview_test.go) implements IndexProcessor but
+	// not io.Closer; it panics if any of its methods are called, so a
+	// passing test proves closeIndexProcessorIfCloser never touches it.
+	require.NotPanics(t, func() {
+		closeIndexProcessorIfCloser(failingIndexProcessor{}, "table1", log.NewNopLogger())
+	})
+}
@@ -0,0 +1,62 @@
+package retention
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionScope_NilScopeAllowsEverything(t *testing.T) {
+	var scope *RetentionScope
+	require.True(t, scope.Allows(ChunkEntry{ChunkRef: ChunkRef{UserID: []byte("tenant-a")}}))
+}
+
+func TestRetentionScope_AllowedUsers(t *testing.T) {
+	scope, err := NewRetentionScope([]string{"tenant-a", "tenant-b"}, "")
+	require.NoError(t, err)
+
+	require.True(t, scope.Allows(ChunkEntry{ChunkRef: ChunkRef{UserID: []byte("tenant-a")}}))
+	require.False(t, scope.Allows(ChunkEntry{ChunkRef: ChunkRef{UserID: []byte("tenant-c")}}))
+}
+
+func TestRetentionScope_EmptyAllowListAllowsEveryTenant(t *testing.T) {
+	scope, err := NewRetentionScope(nil, "")
+	require.NoError(t, err)
+
+	require.True(t, scope.Allows(ChunkEntry{ChunkRef: ChunkRef{UserID: []byte("tenant-a")}}))
+	require.True(t, scope.Allows(ChunkEntry{ChunkRef: ChunkRef{UserID: []byte("tenant-z")}}))
+}
+
+func TestRetentionScope_Selector(t *testing.T) {
+	scope, err := NewRetentionScope(nil, `{app="noisy"}`)
+	require.NoError(t, err)
+
+	matching := ChunkEntry{Labels: labels.FromStrings("app", "noisy")}
+	require.True(t, scope.Allows(matching))
+
+	nonMatching := ChunkEntry{Labels: labels.FromStrings("app", "quiet")}
+	require.False(t, scope.Allows(nonMatching))
+}
+
+func TestRetentionScope_UserAndSelectorAreBothRequired(t *testing.T) {
+	scope, err := NewRetentionScope([]string{"tenant-a"}, `{app="noisy"}`)
+	require.NoError(t, err)
+
+	c := ChunkEntry{
+		ChunkRef: ChunkRef{UserID: []byte("tenant-a")},
+		Labels:   labels.FromStrings("app", "quiet"),
+	}
+	require.False(t, scope.Allows(c), "matching user but non-matching labels should not be allowed")
+
+	c.Labels = labels.FromStrings("app", "noisy")
+	require.True(t, scope.Allows(c))
+
+	c.ChunkRef.UserID = []byte("tenant-b")
+	require.False(t, scope.Allows(c), "matching labels but non-matching user should not be allowed")
+}
+
+func TestRetentionScope_InvalidSelector(t *testing.T) {
+	_, err := NewRetentionScope(nil, "{not a valid selector")
+	require.Error(t, err)
+}